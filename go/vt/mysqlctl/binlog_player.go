@@ -8,9 +8,11 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"math/rand"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
@@ -98,6 +100,63 @@ func NewDbClient(dbConfig *mysql.ConnectionParams) *DBClient {
 	return dbClient
 }
 
+// TxnRetryPolicy configures how a transaction run via runInNewTxn is
+// retried after a transient error. Backoff grows from InitialBackoff by
+// Multiplier on each attempt, capped at MaxBackoff, with optional jitter to
+// avoid retry storms across many concurrent players.
+type TxnRetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         bool
+}
+
+// DefaultTxnRetryPolicy is used when a BinlogPlayer is not given an explicit
+// TxnRetryPolicy.
+var DefaultTxnRetryPolicy = TxnRetryPolicy{
+	MaxRetries:     10,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2.0,
+	Jitter:         true,
+}
+
+// backoff returns how long to wait before the given retry attempt
+// (0-based), per the policy's exponential curve and optional jitter.
+func (p TxnRetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 0; i < attempt; i++ {
+		d = time.Duration(float64(d) * p.Multiplier)
+		if d > p.MaxBackoff {
+			d = p.MaxBackoff
+			break
+		}
+	}
+	if p.Jitter && d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d)/2+1))
+	}
+	return d
+}
+
+// isRetryableError returns true for mysql errors that are expected to clear
+// up on their own: deadlocks, lock-wait timeouts, and connection errors.
+func isRetryableError(err error) bool {
+	sqlErr, ok := err.(*mysql.SqlError)
+	if !ok {
+		return false
+	}
+	switch {
+	case sqlErr.Number() == 1213: // deadlock found when trying to get lock
+		return true
+	case sqlErr.Number() == 1205: // lock wait timeout exceeded
+		return true
+	case sqlErr.Number() >= 2000 && sqlErr.Number() <= 2018: // mysql connection errors
+		return true
+	}
+	return false
+}
+
 func (dc *DBClient) handleError(err error) {
 	// log.Errorf("in DBClient handleError %v", err.(error))
 	if sqlErr, ok := err.(*mysql.SqlError); ok {
@@ -164,14 +223,52 @@ func (dc *DBClient) ExecuteFetch(query string, maxrows int, wantfields bool) (*p
 	return &qr, nil
 }
 
+// runInNewTxn begins a transaction, runs f, and commits on success. If f
+// returns a retryable error (see isRetryableError) and retryable is true,
+// the transaction is rolled back and retried with capped exponential
+// backoff per policy; a non-retryable error (or retryable exhausted) is
+// returned immediately without further retries.
+func (dc *DBClient) runInNewTxn(retryable bool, policy TxnRetryPolicy, f func() error) (err error) {
+	for attempt := 0; ; attempt++ {
+		// handleError closes the connection on a connection error, so
+		// dial a new one before retrying.
+		if dc.dbConn == nil {
+			if err = dc.Connect(); err != nil {
+				return err
+			}
+		}
+		if err = dc.Begin(); err != nil {
+			return err
+		}
+		if err = f(); err != nil {
+			if dc.dbConn != nil {
+				_ = dc.Rollback()
+			}
+			if !retryable || !isRetryableError(err) || attempt >= policy.MaxRetries {
+				return err
+			}
+			log.Infof("Retryable error in txn, attempt %v: %v", attempt, err)
+			time.Sleep(policy.backoff(attempt))
+			continue
+		}
+		return dc.Commit()
+	}
+}
+
 // blplStats is the internal stats of this player
 type blplStats struct {
-	queryCount    *stats.Counters
-	txnCount      *stats.Counters
-	queriesPerSec *stats.Rates
-	txnsPerSec    *stats.Rates
-	txnTime       *stats.Timings
-	queryTime     *stats.Timings
+	queryCount       *stats.Counters
+	txnCount         *stats.Counters
+	queriesPerSec    *stats.Rates
+	txnsPerSec       *stats.Rates
+	txnTime          *stats.Timings
+	queryTime        *stats.Timings
+	txnRetryCount    *stats.Counters
+	txnRetryTime     *stats.Timings
+	workerBusyTime   *stats.Timings
+	workerStallTime  *stats.Timings
+	sourceFailovers  *stats.Counters
+	sourceDialErrors *stats.Counters
 }
 
 func NewBlplStats() *blplStats {
@@ -182,6 +279,12 @@ func NewBlplStats() *blplStats {
 	bs.txnsPerSec = stats.NewRates("", bs.txnCount, 15, 60e9)
 	bs.txnTime = stats.NewTimings("")
 	bs.queryTime = stats.NewTimings("")
+	bs.txnRetryCount = stats.NewCounters("")
+	bs.txnRetryTime = stats.NewTimings("")
+	bs.workerBusyTime = stats.NewTimings("")
+	bs.workerStallTime = stats.NewTimings("")
+	bs.sourceFailovers = stats.NewCounters("")
+	bs.sourceDialErrors = stats.NewCounters("")
 	return bs
 }
 
@@ -195,10 +298,24 @@ func (bs *blplStats) statsJSON() string {
 	fmt.Fprintf(buf, "\n \"TxnPerSec\": %v", bs.txnsPerSec)
 	fmt.Fprintf(buf, "\n \"TxnTime\": %v,", bs.txnTime)
 	fmt.Fprintf(buf, "\n \"QueryTime\": %v,", bs.queryTime)
+	fmt.Fprintf(buf, "\n \"TxnRetryCount\": %v,", bs.txnRetryCount)
+	fmt.Fprintf(buf, "\n \"TxnRetryTime\": %v,", bs.txnRetryTime)
+	fmt.Fprintf(buf, "\n \"WorkerBusyTime\": %v,", bs.workerBusyTime)
+	fmt.Fprintf(buf, "\n \"WorkerStallTime\": %v,", bs.workerStallTime)
+	fmt.Fprintf(buf, "\n \"SourceFailovers\": %v,", bs.sourceFailovers)
+	fmt.Fprintf(buf, "\n \"SourceDialErrors\": %v", bs.sourceDialErrors)
 	fmt.Fprintf(buf, "\n}")
 	return buf.String()
 }
 
+// EventHandler is invoked by BinlogPlayer for every event of the SqlType it
+// is registered for, before the player's own handling of that event. It lets
+// callers inject custom logic (metrics, filters, transformations, dry-run
+// capture) without forking processBinlogEvent or handleTxn. Returning an
+// error aborts processing of the current event the same way a builtin
+// failure would.
+type EventHandler func(blp *BinlogPlayer, event *cproto.BinlogResponse) error
+
 // BinlogPlayer is handling reading a stream of updates from BinlogServer
 type BinlogPlayer struct {
 	// filters for replication
@@ -221,11 +338,129 @@ type BinlogPlayer struct {
 	maxTxnInterval time.Duration
 	execDdl        bool
 
+	// eventHandlers dispatches by SqlType before the builtin event handling
+	// runs; defaultEventHandler, if set, is used for any SqlType with no
+	// entry in eventHandlers.
+	eventHandlers       map[cproto.SqlType]EventHandler
+	defaultEventHandler EventHandler
+
+	// DatabaseRewrites and TableRewrites are consulted before a DML or DDL
+	// is sent to dbClient.ExecuteFetch: when a DML's parsed stream-comment
+	// table matches a TableRewrites key, or a backquoted database name in a
+	// DDL matches a DatabaseRewrites key, it is rewritten to the mapped
+	// value. Table names in DDLs and database names in DMLs are not
+	// rewritten. This allows a single player to fan multiple source
+	// shards into a differently named target schema, e.g. for shard-merge
+	// or reshard staging.
+	DatabaseRewrites map[string]string
+	TableRewrites    map[string]string
+
+	// txnRetryPolicy governs how flushTxnBatch retries a batch that fails
+	// with a transient error instead of aborting the player run.
+	txnRetryPolicy TxnRetryPolicy
+
+	// ParallelWorkers, when greater than 1, makes flushTxnBatch partition
+	// the complete transactions in the current batch by PK dependency and
+	// apply the independent partitions concurrently across that many
+	// worker connections, advancing the recovery position only once every
+	// worker has committed its share of the batch. ParallelWorkers <= 1
+	// (the default) preserves the original strictly serial behaviour.
+	ParallelWorkers int
+	dbClientFactory func() (VtClient, error)
+	workerClients   []VtClient
+
+	// lastStreamedBinlogPosition is updated on every event received from
+	// the source. lastResumableBinlogPosition only moves at transaction
+	// boundaries and DDL - the points at which writeRecoveryPosition
+	// persists it - so an interrupted player always resumes at the start
+	// of a transaction rather than replaying it partially. lastEventTime
+	// holds the source event's own timestamp (not the time it was
+	// received locally), so IsCaughtUp reports actual replication lag and
+	// can tell orchestration tooling when it is safe to cut over reads.
+	lastStreamedBinlogPosition  cproto.ReplicationCoordinates
+	lastResumableBinlogPosition cproto.ReplicationCoordinates
+	lastEventTime               time.Time
+
+	// AlternateAddrs lists other BinlogServer addresses that can serve the
+	// same keyrange as recoveryState.Addr. If SourceSelector is left
+	// unset, ApplyBinlogEvents seeds a default round-robin SourceSelector
+	// over recoveryState.Addr and AlternateAddrs; set SourceSelector
+	// explicitly (e.g. to a HealthScoringSelector) to override that
+	// default. Either way, ApplyBinlogEvents rotates among the addresses
+	// on dial failure, mid-stream channel closure, or a non-EOF server
+	// error, instead of aborting.
+	AlternateAddrs []string
+	SourceSelector SourceSelector
+
 	// runtime stats
 	blplStats *blplStats
 }
 
+// DefaultCaughtUpThreshold is the default threshold used by IsCaughtUp.
+const DefaultCaughtUpThreshold = 10 * time.Second
+
+// BinlogPlayerStatus is a point-in-time snapshot of a BinlogPlayer's
+// progress, returned by GetStatus for orchestration tooling (e.g.
+// resharding) that needs to reliably decide when it is safe to cut over
+// reads.
+type BinlogPlayerStatus struct {
+	Uid                         uint32
+	LastStreamedBinlogPosition  cproto.ReplicationCoordinates
+	LastResumableBinlogPosition cproto.ReplicationCoordinates
+	TimeSinceLastEvent          time.Duration
+	IsCaughtUp                  bool
+}
+
+// GetStatus returns a snapshot of the player's streamed and resumable
+// positions. It is meant to be exposed over RPC so external tooling can
+// poll it without needing access to the player's internal state.
+func (blp *BinlogPlayer) GetStatus() *BinlogPlayerStatus {
+	return &BinlogPlayerStatus{
+		Uid:                         blp.uid,
+		LastStreamedBinlogPosition:  blp.lastStreamedBinlogPosition,
+		LastResumableBinlogPosition: blp.lastResumableBinlogPosition,
+		TimeSinceLastEvent:          blp.TimeSinceLastEvent(),
+		IsCaughtUp:                  blp.IsCaughtUp(DefaultCaughtUpThreshold),
+	}
+}
+
+// TimeSinceLastEvent returns the delta between now and the source
+// timestamp of the last event the player processed - i.e. replication lag,
+// not how recently the connection delivered bytes - or 0 if it hasn't
+// processed a timestamped event yet.
+func (blp *BinlogPlayer) TimeSinceLastEvent() time.Duration {
+	if blp.lastEventTime.IsZero() {
+		return 0
+	}
+	return time.Now().Sub(blp.lastEventTime)
+}
+
+// IsCaughtUp returns true when the source event the player last processed
+// is more recent than threshold ago, i.e. replication lag is under
+// threshold. A threshold <= 0 uses DefaultCaughtUpThreshold.
+func (blp *BinlogPlayer) IsCaughtUp(threshold time.Duration) bool {
+	if threshold <= 0 {
+		threshold = DefaultCaughtUpThreshold
+	}
+	if blp.lastEventTime.IsZero() {
+		return false
+	}
+	return blp.TimeSinceLastEvent() < threshold
+}
+
 func NewBinlogPlayer(dbClient VtClient, keyRange key.KeyRange, uid uint32, startPosition *binlogRecoveryState, tables []string, txnBatch int, maxTxnInterval time.Duration, execDdl bool) (*BinlogPlayer, error) {
+	return NewBinlogPlayerWithHandlers(dbClient, keyRange, uid, startPosition, tables, txnBatch, maxTxnInterval, execDdl, nil, nil, nil, 0, nil)
+}
+
+// NewBinlogPlayerWithHandlers is like NewBinlogPlayer but additionally
+// accepts a registry of per-SqlType event handlers (see EventHandler), a
+// pair of database/table rewrite maps applied to DMLs and DDLs before they
+// are executed against dbClient, and parallel-apply options. A nil handlers
+// map or nil rewrite map is equivalent to not registering anything.
+// parallelWorkers <= 1 (or a nil dbClientFactory) keeps the player in its
+// original strictly serial mode; otherwise dbClientFactory is used to dial
+// one additional VtClient per worker the first time a batch is flushed.
+func NewBinlogPlayerWithHandlers(dbClient VtClient, keyRange key.KeyRange, uid uint32, startPosition *binlogRecoveryState, tables []string, txnBatch int, maxTxnInterval time.Duration, execDdl bool, handlers map[cproto.SqlType]EventHandler, databaseRewrites map[string]string, tableRewrites map[string]string, parallelWorkers int, dbClientFactory func() (VtClient, error)) (*BinlogPlayer, error) {
 	if err := startPositionValid(startPosition); err != nil {
 		return nil, err
 	}
@@ -234,6 +469,8 @@ func NewBinlogPlayer(dbClient VtClient, keyRange key.KeyRange, uid uint32, start
 	blp.keyRange = keyRange
 	blp.uid = uid
 	blp.recoveryState = *startPosition
+	blp.lastStreamedBinlogPosition = startPosition.Position
+	blp.lastResumableBinlogPosition = startPosition.Position
 	blp.inTxn = false
 	blp.txnBuffer = make([]*cproto.BinlogResponse, 0, MAX_TXN_BATCH)
 	blp.dbClient = dbClient
@@ -243,16 +480,99 @@ func NewBinlogPlayer(dbClient VtClient, keyRange key.KeyRange, uid uint32, start
 	blp.txnBatch = txnBatch
 	blp.maxTxnInterval = maxTxnInterval
 	blp.execDdl = execDdl
+	if handlers == nil {
+		handlers = make(map[cproto.SqlType]EventHandler)
+	}
+	blp.eventHandlers = handlers
+	blp.DatabaseRewrites = databaseRewrites
+	blp.TableRewrites = tableRewrites
+	blp.txnRetryPolicy = DefaultTxnRetryPolicy
+	blp.ParallelWorkers = parallelWorkers
+	blp.dbClientFactory = dbClientFactory
 	blp.blplStats = NewBlplStats()
 	return blp, nil
 }
 
+// SetTxnRetryPolicy overrides the default retry policy used by
+// flushTxnBatch when a transaction batch hits a transient error.
+func (blp *BinlogPlayer) SetTxnRetryPolicy(policy TxnRetryPolicy) {
+	blp.txnRetryPolicy = policy
+}
+
+// RegisterEventHandler registers handler to run for every event of the
+// given SqlType, replacing any handler previously registered for it.
+func (blp *BinlogPlayer) RegisterEventHandler(sqlType cproto.SqlType, handler EventHandler) {
+	if blp.eventHandlers == nil {
+		blp.eventHandlers = make(map[cproto.SqlType]EventHandler)
+	}
+	blp.eventHandlers[sqlType] = handler
+}
+
+// SetDefaultEventHandler registers handler to run for any event whose
+// SqlType has no entry in the handler registry.
+func (blp *BinlogPlayer) SetDefaultEventHandler(handler EventHandler) {
+	blp.defaultEventHandler = handler
+}
+
+// rewriteTable returns the rewritten name for table according to
+// TableRewrites, or table unchanged if there is no matching rewrite.
+func (blp *BinlogPlayer) rewriteTable(table string) string {
+	if blp.TableRewrites == nil {
+		return table
+	}
+	if rewritten, ok := blp.TableRewrites[table]; ok {
+		return rewritten
+	}
+	return table
+}
+
+// rewriteDatabase returns the rewritten name for database according to
+// DatabaseRewrites, or database unchanged if there is no matching rewrite.
+func (blp *BinlogPlayer) rewriteDatabase(database string) string {
+	if blp.DatabaseRewrites == nil {
+		return database
+	}
+	if rewritten, ok := blp.DatabaseRewrites[database]; ok {
+		return rewritten
+	}
+	return database
+}
+
+// rewriteDmlTable rewrites occurrences of table's quoted identifier inside
+// sql with its mapped name, if TableRewrites has an entry for table.
+func (blp *BinlogPlayer) rewriteDmlTable(sql string, table string) string {
+	rewritten := blp.rewriteTable(table)
+	if rewritten == table {
+		return sql
+	}
+	return strings.Replace(sql, "`"+table+"`", "`"+rewritten+"`", -1)
+}
+
+// rewriteDatabasesInDdl rewrites every quoted occurrence of a database that
+// has a DatabaseRewrites entry, via rewriteDatabase, to its mapped name.
+func (blp *BinlogPlayer) rewriteDatabasesInDdl(sql string) string {
+	for from := range blp.DatabaseRewrites {
+		to := blp.rewriteDatabase(from)
+		if to != from {
+			sql = strings.Replace(sql, "`"+from+"`", "`"+to+"`", -1)
+		}
+	}
+	return sql
+}
+
 func (blp *BinlogPlayer) StatsJSON() string {
-	return blp.blplStats.statsJSON()
+	buf := bytes.NewBuffer(make([]byte, 0, 128))
+	fmt.Fprintf(buf, "{")
+	fmt.Fprintf(buf, "\n \"LastStreamedBinlogPosition\": \"%v\",", blp.lastStreamedBinlogPosition.String())
+	fmt.Fprintf(buf, "\n \"LastResumableBinlogPosition\": \"%v\",", blp.lastResumableBinlogPosition.String())
+	fmt.Fprintf(buf, "\n \"Stats\": %v", blp.blplStats.statsJSON())
+	fmt.Fprintf(buf, "\n}")
+	return buf.String()
 }
 
 func (blp *BinlogPlayer) writeRecoveryPosition(currentPosition *cproto.ReplicationCoordinates) error {
 	blp.recoveryState.Position = *currentPosition
+	blp.lastResumableBinlogPosition = *currentPosition
 	updateRecovery := fmt.Sprintf(UPDATE_RECOVERY,
 		currentPosition.MasterFilename,
 		currentPosition.MasterPosition,
@@ -361,18 +681,319 @@ func ReadStartPosition(dbClient VtClient, uid uint32) (*binlogRecoveryState, err
 }
 
 func (blp *BinlogPlayer) flushTxnBatch() error {
-	for {
-		txnOk, err := blp.handleTxn()
-		if err != nil {
-			return err
+	if blp.ParallelWorkers > 1 && blp.dbClientFactory != nil {
+		return blp.flushTxnBatchParallel()
+	}
+
+	var err error
+	if dc, ok := blp.dbClient.(*DBClient); ok {
+		err = blp.flushTxnBatchViaRunInNewTxn(dc)
+	} else {
+		// Not a *DBClient (e.g. DummyVtClient, or a test double): fall
+		// back to a hand-rolled Begin/handleTxn/Commit loop with the same
+		// retry/backoff policy, since runInNewTxn only exists on DBClient.
+		err = blp.flushTxnBatchSerial()
+	}
+	if err != nil {
+		return err
+	}
+	blp.inTxn = false
+	blp.txnBuffer = blp.txnBuffer[:0]
+	blp.txnIndex = 0
+	return nil
+}
+
+// flushTxnBatchViaRunInNewTxn applies the currently buffered batch inside a
+// single transaction via dc.runInNewTxn, which retries the whole batch with
+// capped exponential backoff (per blp.txnRetryPolicy) on a retryable error.
+func (blp *BinlogPlayer) flushTxnBatchViaRunInNewTxn(dc *DBClient) error {
+	retryStartTime := time.Now()
+	attempt := 0
+	retried := false
+	err := dc.runInNewTxn(true, blp.txnRetryPolicy, func() error {
+		if attempt > 0 {
+			retried = true
+			log.Infof("Retrying txn, attempt %v", attempt)
+			blp.blplStats.txnRetryCount.Add("TxnRetryCount", 1)
+		}
+		attempt++
+		return blp.handleTxn()
+	})
+	if retried {
+		blp.blplStats.txnRetryTime.Record("TxnRetryTime", retryStartTime)
+	}
+	return err
+}
+
+// flushTxnBatchSerial is the flushTxnBatchViaRunInNewTxn equivalent for a
+// VtClient that isn't a *DBClient and so has no runInNewTxn of its own.
+func (blp *BinlogPlayer) flushTxnBatchSerial() error {
+	retryStartTime := time.Now()
+	retried := false
+	for attempt := 0; ; attempt++ {
+		if err := blp.dbClient.Begin(); err != nil {
+			return fmt.Errorf("Failed query 'BEGIN', err: %s", err)
 		}
-		if txnOk {
+		err := blp.handleTxn()
+		if err == nil {
+			if err := blp.dbClient.Commit(); err != nil {
+				return fmt.Errorf("Failed query 'COMMIT', err: %s", err)
+			}
 			break
-		} else {
-			log.Infof("Retrying txn")
-			time.Sleep(1)
 		}
+		_ = blp.dbClient.Rollback()
+		if !isRetryableError(err) || attempt >= blp.txnRetryPolicy.MaxRetries {
+			return err
+		}
+		retried = true
+		backoff := blp.txnRetryPolicy.backoff(attempt)
+		log.Infof("Retrying txn, attempt %v, backoff %v", attempt+1, backoff)
+		blp.blplStats.txnRetryCount.Add("TxnRetryCount", 1)
+		time.Sleep(backoff)
+	}
+	if retried {
+		blp.blplStats.txnRetryTime.Record("TxnRetryTime", retryStartTime)
+	}
+	return nil
+}
+
+// initWorkers lazily dials one VtClient per ParallelWorkers via
+// dbClientFactory, the first time a batch is flushed in parallel mode.
+func (blp *BinlogPlayer) initWorkers() error {
+	if len(blp.workerClients) == blp.ParallelWorkers {
+		return nil
+	}
+	workerClients := make([]VtClient, blp.ParallelWorkers)
+	for i := 0; i < blp.ParallelWorkers; i++ {
+		client, err := blp.dbClientFactory()
+		if err != nil {
+			return fmt.Errorf("Error creating worker %v db client, err %v", i, err)
+		}
+		if err := client.Connect(); err != nil {
+			return fmt.Errorf("Error connecting worker %v db client, err %v", i, err)
+		}
+		workerClients[i] = client
+	}
+	blp.workerClients = workerClients
+	return nil
+}
+
+// splitTxns splits a flushed batch into one slice of events per complete
+// transaction, each ending at its COMMIT event.
+func splitTxns(buffer []*cproto.BinlogResponse) [][]*cproto.BinlogResponse {
+	var txns [][]*cproto.BinlogResponse
+	var cur []*cproto.BinlogResponse
+	for _, event := range buffer {
+		cur = append(cur, event)
+		if event.Data.SqlType == cproto.COMMIT {
+			txns = append(txns, cur)
+			cur = nil
+		}
+	}
+	return txns
+}
+
+// partitionTxns assigns each transaction in txns to one of workers worker
+// indexes. Transactions that touch the same (table, pk) dependency key, as
+// parsed from their DMLs' _stream comments, are kept together on the same
+// worker so they still apply in their original relative order; unrelated
+// transactions are spread round robin. A transaction with no recognizable
+// keys (e.g. no DMLs, or DMLs without a _stream comment) is treated as
+// independent of everything else.
+func partitionTxns(txns [][]*cproto.BinlogResponse, workers int) [][][]*cproto.BinlogResponse {
+	group := make([]int, len(txns))
+	for i := range group {
+		group[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if group[i] != i {
+			group[i] = find(group[i])
+		}
+		return group[i]
+	}
+	union := func(a, b int) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			group[ra] = rb
+		}
+	}
+
+	lastTxnForKey := make(map[string]int)
+	for i, txn := range txns {
+		for _, event := range txn {
+			if event.Data.SqlType != cproto.DML {
+				continue
+			}
+			for _, sql := range event.Data.Sql {
+				key, ok := streamCommentKey(sql)
+				if !ok {
+					continue
+				}
+				if prev, ok := lastTxnForKey[key]; ok {
+					union(prev, i)
+				}
+				lastTxnForKey[key] = i
+			}
+		}
+	}
+
+	buckets := make([][][]*cproto.BinlogResponse, workers)
+	groupWorker := make(map[int]int)
+	nextWorker := 0
+	for i, txn := range txns {
+		root := find(i)
+		w, ok := groupWorker[root]
+		if !ok {
+			w = nextWorker % workers
+			nextWorker++
+			groupWorker[root] = w
+		}
+		buckets[w] = append(buckets[w], txn)
+	}
+	return buckets
+}
+
+// applyTxnsOnWorker begins a single transaction on client and applies every
+// matched DML across bucket's transactions, in the order they were
+// originally buffered, without committing. The caller (flushTxnBatchParallel)
+// only commits once every worker in the batch has applied successfully, so
+// a failure partway through any one worker's bucket can still be rolled
+// back everywhere instead of leaving that worker's partial progress, or
+// another worker's already-applied bucket, committed ahead of the
+// persisted checkpoint.
+func (blp *BinlogPlayer) applyTxnsOnWorker(client VtClient, bucket [][]*cproto.BinlogResponse) error {
+	if err := client.Begin(); err != nil {
+		return fmt.Errorf("Failed query 'BEGIN', err: %s", err)
+	}
+	for _, txn := range bucket {
+		for _, event := range txn {
+			if event.Data.SqlType != cproto.DML || !blp.dmlTableMatch(event.Data.Sql) {
+				continue
+			}
+			for _, sql := range event.Data.Sql {
+				if table, ok := streamCommentTable(sql); ok {
+					sql = blp.rewriteDmlTable(sql, table)
+				}
+				if _, err := client.ExecuteFetch(sql, 0, false); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyBucketsWithRetry dispatches buckets to blp.workerClients concurrently,
+// one worker transaction per non-empty bucket, and commits every one of
+// them only once every worker has applied its bucket without error. A
+// retryable error (see isRetryableError) from any worker rolls every
+// worker back and retries the whole batch - not just the failed bucket -
+// with capped exponential backoff per blp.txnRetryPolicy; a non-retryable
+// error (or retries exhausted) is returned immediately.
+func (blp *BinlogPlayer) applyBucketsWithRetry(buckets [][][]*cproto.BinlogResponse) error {
+	for attempt := 0; ; attempt++ {
+		errs := make([]error, len(buckets))
+		var wg sync.WaitGroup
+		for i, bucket := range buckets {
+			if len(bucket) == 0 {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, bucket [][]*cproto.BinlogResponse) {
+				defer wg.Done()
+				busyStart := time.Now()
+				errs[i] = blp.applyTxnsOnWorker(blp.workerClients[i], bucket)
+				blp.blplStats.workerBusyTime.Record("WorkerBusyTime", busyStart)
+			}(i, bucket)
+		}
+		stallStart := time.Now()
+		wg.Wait()
+		blp.blplStats.workerStallTime.Record("WorkerStallTime", stallStart)
+
+		var firstErr error
+		for i, err := range errs {
+			if len(buckets[i]) == 0 {
+				continue
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr == nil {
+			for i, bucket := range buckets {
+				if len(bucket) == 0 {
+					continue
+				}
+				if err := blp.workerClients[i].Commit(); err != nil {
+					return fmt.Errorf("Failed query 'COMMIT', err: %s", err)
+				}
+			}
+			return nil
+		}
+
+		for i, bucket := range buckets {
+			if len(bucket) == 0 {
+				continue
+			}
+			_ = blp.workerClients[i].Rollback()
+		}
+		if !isRetryableError(firstErr) || attempt >= blp.txnRetryPolicy.MaxRetries {
+			return firstErr
+		}
+		backoff := blp.txnRetryPolicy.backoff(attempt)
+		log.Infof("Retrying parallel txn batch, attempt %v, backoff %v", attempt+1, backoff)
+		blp.blplStats.txnRetryCount.Add("TxnRetryCount", 1)
+		time.Sleep(backoff)
+	}
+}
+
+// flushTxnBatchParallel partitions the complete transactions currently
+// buffered (see partitionTxns) and applies the independent partitions
+// concurrently across blp.workerClients, each on its own open (uncommitted)
+// transaction. It is a real barrier: no worker's transaction is committed
+// until every worker has finished applying its entire partition without
+// error; if any worker fails while applying, every worker's transaction
+// (including ones that applied cleanly) is rolled back instead, so an apply
+// failure never leaves some workers' work committed while the persisted
+// checkpoint stays behind. The workers' commits are issued one after
+// another and the checkpoint is written afterwards in a separate
+// transaction on blp.dbClient, so a failure during those commits or the
+// checkpoint write can leave committed work ahead of the checkpoint.
+// A retryable error (see isRetryableError) from any one worker retries the
+// whole batch across all workers, with the same capped exponential backoff
+// (blp.txnRetryPolicy) the serial flush path uses, instead of aborting the
+// player run on the first transient deadlock or lock-wait timeout.
+func (blp *BinlogPlayer) flushTxnBatchParallel() error {
+	if err := blp.initWorkers(); err != nil {
+		return err
+	}
+
+	txns := splitTxns(blp.txnBuffer)
+	if len(txns) == 0 {
+		blp.inTxn = false
+		blp.txnBuffer = blp.txnBuffer[:0]
+		blp.txnIndex = 0
+		return nil
+	}
+	buckets := partitionTxns(txns, len(blp.workerClients))
+
+	if err := blp.applyBucketsWithRetry(buckets); err != nil {
+		return err
+	}
+
+	lastTxn := txns[len(txns)-1]
+	lastEvent := lastTxn[len(lastTxn)-1]
+	if err := blp.dbClient.Begin(); err != nil {
+		return fmt.Errorf("Failed query 'BEGIN', err: %s", err)
 	}
+	if err := blp.writeRecoveryPosition(&lastEvent.Position.Position); err != nil {
+		return err
+	}
+	if err := blp.dbClient.Commit(); err != nil {
+		return fmt.Errorf("Failed query 'COMMIT', err: %s", err)
+	}
+
 	blp.inTxn = false
 	blp.txnBuffer = blp.txnBuffer[:0]
 	blp.txnIndex = 0
@@ -380,6 +1001,16 @@ func (blp *BinlogPlayer) flushTxnBatch() error {
 }
 
 func (blp *BinlogPlayer) processBinlogEvent(binlogResponse *cproto.BinlogResponse) (err error) {
+	blp.lastStreamedBinlogPosition = binlogResponse.Position.Position
+	// Use the source event's own timestamp, not the time we received it
+	// locally, so TimeSinceLastEvent/IsCaughtUp measure real replication
+	// lag rather than "time since the socket last delivered bytes". A
+	// zero timestamp (e.g. on some non-DML markers) leaves lastEventTime
+	// at its previous value rather than resetting the lag to 0.
+	if binlogResponse.Position.Timestamp > 0 {
+		blp.lastEventTime = time.Unix(binlogResponse.Position.Timestamp, 0)
+	}
+
 	// Read event
 	if binlogResponse.Error != "" {
 		// This is to handle the terminal condition where the client is exiting but there
@@ -406,6 +1037,16 @@ func (blp *BinlogPlayer) processBinlogEvent(binlogResponse *cproto.BinlogRespons
 		}
 	}
 
+	if handler, ok := blp.eventHandlers[binlogResponse.Data.SqlType]; ok {
+		if err := handler(blp, binlogResponse); err != nil {
+			return err
+		}
+	} else if blp.defaultEventHandler != nil {
+		if err := blp.defaultEventHandler(blp, binlogResponse); err != nil {
+			return err
+		}
+	}
+
 	switch binlogResponse.Data.SqlType {
 	case cproto.DDL:
 		if blp.txnIndex > 0 {
@@ -460,6 +1101,9 @@ func (blp *BinlogPlayer) handleDdl(ddlEvent *cproto.BinlogResponse) error {
 		if sql == "" {
 			continue
 		}
+		if blp.DatabaseRewrites != nil {
+			sql = blp.rewriteDatabasesInDdl(sql)
+		}
 		if _, err := blp.dbClient.ExecuteFetch(sql, 0, false); err != nil {
 			return fmt.Errorf("Error %v in executing sql %v", err, sql)
 		}
@@ -477,6 +1121,35 @@ func (blp *BinlogPlayer) handleDdl(ddlEvent *cproto.BinlogResponse) error {
 	return nil
 }
 
+// streamCommentTable extracts the table name vtgate/vttablet embedded in the
+// '/* _stream ... */' comment of a DML, returning ok=false if sql has no
+// such comment.
+func streamCommentTable(sql string) (table string, ok bool) {
+	streamCommentIndex := strings.Index(sql, BLPL_STREAM_COMMENT_START)
+	if streamCommentIndex == -1 {
+		return "", false
+	}
+	table = strings.TrimSpace(strings.Split(sql[(streamCommentIndex+len(BLPL_STREAM_COMMENT_START)):], BLPL_SPACE)[0])
+	return table, true
+}
+
+// streamCommentKey returns the full "<table> (<pk columns>) (<pk values>)"
+// body of a DML's _stream comment, used to tell which transactions in a
+// batch touch the same row and so must stay on the same worker when
+// applying a batch in parallel (see partitionTxns).
+func streamCommentKey(sql string) (string, bool) {
+	streamCommentIndex := strings.Index(sql, BLPL_STREAM_COMMENT_START)
+	if streamCommentIndex == -1 {
+		return "", false
+	}
+	rest := sql[streamCommentIndex+len(BLPL_STREAM_COMMENT_START):]
+	end := strings.Index(rest, "*/")
+	if end == -1 {
+		end = len(rest)
+	}
+	return strings.TrimSpace(rest[:end]), true
+}
+
 func (blp *BinlogPlayer) dmlTableMatch(sqlSlice []string) bool {
 	if blp.tables == nil {
 		return true
@@ -490,13 +1163,12 @@ func (blp *BinlogPlayer) dmlTableMatch(sqlSlice []string) bool {
 		if firstKw != "insert" && firstKw != "update" && firstKw != "delete" {
 			continue
 		}
-		streamCommentIndex := strings.Index(sql, BLPL_STREAM_COMMENT_START)
-		if streamCommentIndex == -1 {
+		tableName, ok := streamCommentTable(sql)
+		if !ok {
 			// log.Warningf("sql doesn't have stream comment '%v'", sql)
 			// If sql doesn't have stream comment, don't match
 			return false
 		}
-		tableName := strings.TrimSpace(strings.Split(sql[(streamCommentIndex+len(BLPL_STREAM_COMMENT_START)):], BLPL_SPACE)[0])
 		for _, table := range blp.tables {
 			if tableName == table {
 				return true
@@ -512,13 +1184,20 @@ func (blp *BinlogPlayer) dmlTableMatch(sqlSlice []string) bool {
 // blp.TxnBuffer contains 'n' complete txns, we
 // send one begin at the start and then ignore blp.txnIndex - 1 "Commit" events
 // and commit the entire batch at the last commit.
-func (blp *BinlogPlayer) handleTxn() (bool, error) {
+// handleTxn applies every matched DML in the currently buffered batch and
+// writes the resulting recovery position. It does not manage the
+// transaction boundary itself: the caller begins before calling handleTxn
+// and commits or rolls back around it (see flushTxnBatch), so a retryable
+// error (see isRetryableError) can simply be returned like any other error
+// and the caller decides whether and how to retry.
+func (blp *BinlogPlayer) handleTxn() error {
 	var err error
 
 	dmlMatch := 0
 	txnCount := 0
 	var queryCount int64
-	var txnStartTime, queryStartTime time.Time
+	txnStartTime := time.Now()
+	var queryStartTime time.Time
 
 	for _, dmlEvent := range blp.txnBuffer {
 		switch dmlEvent.Data.SqlType {
@@ -530,68 +1209,229 @@ func (blp *BinlogPlayer) handleTxn() (bool, error) {
 				continue
 			}
 			if err = blp.writeRecoveryPosition(&dmlEvent.Position.Position); err != nil {
-				return false, err
-			}
-			if err = blp.dbClient.Commit(); err != nil {
-				return false, fmt.Errorf("Failed query 'COMMIT', err: %s", err)
+				return err
 			}
 			// added 1 for recovery dml
-			queryCount += 2
+			queryCount += 1
 			blp.blplStats.queryCount.Add("QueryCount", queryCount)
 			blp.blplStats.txnCount.Add("TxnCount", int64(blp.txnIndex))
 			blp.blplStats.txnTime.Record("TxnTime", txnStartTime)
 		case cproto.DML:
 			if blp.dmlTableMatch(dmlEvent.Data.Sql) {
 				dmlMatch += 1
-				if dmlMatch == 1 {
-					if err = blp.dbClient.Begin(); err != nil {
-						return false, fmt.Errorf("Failed query 'BEGIN', err: %s", err)
-					}
-					queryCount += 1
-					txnStartTime = time.Now()
-				}
-
 				for _, sql := range dmlEvent.Data.Sql {
 					queryStartTime = time.Now()
+					if table, ok := streamCommentTable(sql); ok {
+						sql = blp.rewriteDmlTable(sql, table)
+					}
 					if _, err = blp.dbClient.ExecuteFetch(sql, 0, false); err != nil {
-						if sqlErr, ok := err.(*mysql.SqlError); ok {
-							// Deadlock found when trying to get lock
-							// Rollback this transaction and exit.
-							if sqlErr.Number() == 1213 {
-								log.Infof("Detected deadlock, returning")
-								_ = blp.dbClient.Rollback()
-								return false, nil
-							}
-						}
-						return false, err
+						// Deadlock, lock-wait timeout, dropped connection,
+						// or any other failure: let the caller decide,
+						// via isRetryableError, whether to roll back and
+						// retry the whole batch.
+						return err
 					}
 					blp.blplStats.txnTime.Record("QueryTime", queryStartTime)
 				}
 				queryCount += int64(len(dmlEvent.Data.Sql))
 			}
 		default:
-			return false, fmt.Errorf("Invalid SqlType %v", dmlEvent.Data.SqlType)
+			return fmt.Errorf("Invalid SqlType %v", dmlEvent.Data.SqlType)
+		}
+	}
+	return nil
+}
+
+// SourceSelector picks the BinlogServer address to try next after current
+// has failed with lastErr. ApplyBinlogEvents dials recoveryState.Addr first
+// without consulting the selector and calls Next only after a failed
+// attempt, always with that attempt's non-nil error. It also returns how long ApplyBinlogEvents should wait before dialing the
+// returned address.
+type SourceSelector interface {
+	Next(current string, lastErr error) (addr string, backoff time.Duration)
+}
+
+// RoundRobinSelector cycles through Addrs in order, wrapping around, with
+// no backoff of its own.
+type RoundRobinSelector struct {
+	Addrs []string
+}
+
+func (s *RoundRobinSelector) Next(current string, lastErr error) (string, time.Duration) {
+	if len(s.Addrs) == 0 {
+		return current, 0
+	}
+	for i, addr := range s.Addrs {
+		if addr == current {
+			return s.Addrs[(i+1)%len(s.Addrs)], 0
+		}
+	}
+	return s.Addrs[0], 0
+}
+
+// HealthScoringSelector picks whichever of Addrs has the best recent
+// health, demoting an address after DemoteAfter consecutive errors and
+// backing it off exponentially before it is offered again - similar in
+// spirit to orchestrator's topology-aware instance scoring.
+type HealthScoringSelector struct {
+	Addrs          []string
+	DemoteAfter    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	mu         sync.Mutex
+	errorCount map[string]int
+	retryAfter map[string]time.Time
+}
+
+// NewHealthScoringSelector returns a HealthScoringSelector over addrs with
+// reasonable defaults: demote after 3 consecutive errors, backing off from
+// 1s up to a cap of 1 minute.
+func NewHealthScoringSelector(addrs []string) *HealthScoringSelector {
+	return &HealthScoringSelector{
+		Addrs:          addrs,
+		DemoteAfter:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		errorCount:     make(map[string]int),
+		retryAfter:     make(map[string]time.Time),
+	}
+}
+
+func (s *HealthScoringSelector) Next(current string, lastErr error) (string, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lastErr != nil {
+		s.errorCount[current]++
+	}
+
+	now := time.Now()
+	best := ""
+	bestErrors := -1
+	for _, addr := range s.Addrs {
+		if addr == current && lastErr != nil {
+			continue
 		}
+		if until, ok := s.retryAfter[addr]; ok && until.After(now) {
+			continue
+		}
+		if errors := s.errorCount[addr]; best == "" || errors < bestErrors {
+			best = addr
+			bestErrors = errors
+		}
+	}
+	if best == "" {
+		// every address is backed off: fall back to plain round robin
+		// over the full list rather than stalling forever.
+		for i, addr := range s.Addrs {
+			if addr == current {
+				best = s.Addrs[(i+1)%len(s.Addrs)]
+				break
+			}
+		}
+		if best == "" && len(s.Addrs) > 0 {
+			best = s.Addrs[0]
+		}
+	}
+
+	if s.errorCount[best] >= s.DemoteAfter {
+		backoff := s.InitialBackoff
+		for i := s.DemoteAfter; i < s.errorCount[best] && backoff < s.MaxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+		s.retryAfter[best] = now.Add(backoff)
+		return best, backoff
 	}
-	return true, nil
+	return best, 0
 }
 
-// ApplyBinlogEvents makes a gob rpc request to BinlogServer
-// and processes the events.
+// MarkHealthy resets the error count and any backoff tracked for addr,
+// after the player has successfully dialed it.
+func (s *HealthScoringSelector) MarkHealthy(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.errorCount, addr)
+	delete(s.retryAfter, addr)
+}
+
+// ApplyBinlogEvents makes a gob rpc request to BinlogServer and processes
+// the events. If SourceSelector is set, a dial failure, a mid-stream
+// responseChan closure, or a non-EOF server error rotates to the next
+// address the selector picks (among AlternateAddrs and the originally
+// configured Addr) and resumes from lastResumableBinlogPosition instead of
+// aborting the player run.
 func (blp *BinlogPlayer) ApplyBinlogEvents(interrupted chan struct{}) error {
+	if blp.SourceSelector == nil && len(blp.AlternateAddrs) > 0 {
+		// AlternateAddrs alone is enough to enable failover: seed a
+		// default round-robin selector over recoveryState.Addr plus the
+		// alternates, so callers don't also have to hand-construct one.
+		blp.SourceSelector = &RoundRobinSelector{Addrs: append([]string{blp.recoveryState.Addr}, blp.AlternateAddrs...)}
+	}
+
+	addr := blp.recoveryState.Addr
+	for {
+		select {
+		case <-interrupted:
+			return nil
+		default:
+		}
+
+		err := blp.applyBinlogEventsOnce(addr, interrupted)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-interrupted:
+			return nil
+		default:
+		}
+
+		if blp.SourceSelector == nil {
+			return err
+		}
+
+		log.Errorf("BinlogPlayer client %v: %v, failing over from %v", blp.uid, err, addr)
+		blp.blplStats.sourceFailovers.Add("SourceFailovers", 1)
+		next, backoff := blp.SourceSelector.Next(addr, err)
+		if backoff > 0 {
+			time.Sleep(backoff)
+		}
+		// The next source streams again from the last persisted position,
+		// so drop whatever was buffered since then instead of applying it
+		// twice.
+		blp.recoveryState.Position = blp.lastResumableBinlogPosition
+		blp.inTxn = false
+		blp.txnBuffer = blp.txnBuffer[:0]
+		blp.txnIndex = 0
+		addr = next
+	}
+}
+
+// applyBinlogEventsOnce dials addr once and streams events from it until
+// the stream ends, the player is interrupted, or an error occurs.
+func (blp *BinlogPlayer) applyBinlogEventsOnce(addr string, interrupted chan struct{}) error {
 	log.Infof("BinlogPlayer client %v for keyrange '%v-%v' starting @ '%v'",
 		blp.uid,
 		blp.keyRange.Start.Hex(),
 		blp.keyRange.End.Hex(),
 		blp.recoveryState.Position)
 
-	log.Infof("Dialing server @ %v", blp.recoveryState.Addr)
-	rpcClient, err := rpcplus.DialHTTP("tcp", blp.recoveryState.Addr)
-	defer rpcClient.Close()
+	log.Infof("Dialing server @ %v", addr)
+	rpcClient, err := rpcplus.DialHTTP("tcp", addr)
 	if err != nil {
+		blp.blplStats.sourceDialErrors.Add("SourceDialErrors", 1)
 		log.Errorf("Error in dialing to vt_binlog_server, %v", err)
 		return fmt.Errorf("Error in dialing to vt_binlog_server, %v", err)
 	}
+	defer rpcClient.Close()
+
+	if hs, ok := blp.SourceSelector.(*HealthScoringSelector); ok {
+		hs.MarkHealthy(addr)
+	}
 
 	responseChan := make(chan *cproto.BinlogResponse)
 	log.Infof("making rpc request @ %v for keyrange %v-%v", blp.recoveryState.Position.String(), blp.keyRange.Start.Hex(), blp.keyRange.End.Hex())
@@ -601,23 +1441,42 @@ func (blp *BinlogPlayer) ApplyBinlogEvents(interrupted chan struct{}) error {
 	}
 	resp := rpcClient.StreamGo("BinlogServer.ServeBinlog", blServeRequest, responseChan)
 
-processLoop:
+	if err := blp.consumeBinlogStream(responseChan, interrupted); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		// Resume from the last transaction/DDL boundary we actually
+		// persisted, not mid-transaction, so a retry on another source
+		// doesn't skip or duplicate work.
+		blp.recoveryState.Position = blp.lastResumableBinlogPosition
+		return fmt.Errorf("Error received from ServeBinlog %v", resp.Error)
+	}
+	return nil
+}
+
+// consumeBinlogStream reads responses off responseChan, applying each via
+// processBinlogEvent, until the channel is closed (stream ended normally),
+// the player is interrupted, or processBinlogEvent fails. A failure on a
+// response carrying a normal, expected end-of-stream EOF marker is not
+// treated as an error worth failing over for: processBinlogEvent has
+// already flushed any pending txns and saved the last EOF group id by the
+// time it returns, so the stream simply ended where it was supposed to.
+func (blp *BinlogPlayer) consumeBinlogStream(responseChan <-chan *cproto.BinlogResponse, interrupted chan struct{}) error {
 	for {
 		select {
 		case response, ok := <-responseChan:
 			if !ok {
-				break processLoop
+				return nil
 			}
-			err = blp.processBinlogEvent(response)
-			if err != nil {
+			isEOF := strings.Contains(response.Error, "EOF")
+			if err := blp.processBinlogEvent(response); err != nil {
+				if isEOF {
+					return nil
+				}
 				return fmt.Errorf("Error in processing binlog event %v", err)
 			}
 		case <-interrupted:
 			return nil
 		}
 	}
-	if resp.Error != nil {
-		return fmt.Errorf("Error received from ServeBinlog %v", resp.Error)
-	}
-	return nil
 }
@@ -0,0 +1,415 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/mysql"
+	"github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/key"
+	cproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+// flakyVtClient is a VtClient test double whose ExecuteFetch fails with a
+// retryable mysql error on every call made during the first
+// failUntilAttempt transactions (counted by Begin), then succeeds, so tests
+// can exercise flushTxnBatch's retry path.
+type flakyVtClient struct {
+	failUntilAttempt int
+	attempt          int
+	executeCount     int
+	commitCount      int
+	rollbackCount    int
+}
+
+func (c *flakyVtClient) Connect() error { return nil }
+func (c *flakyVtClient) Begin() error {
+	c.attempt++
+	return nil
+}
+func (c *flakyVtClient) Commit() error {
+	c.commitCount++
+	return nil
+}
+func (c *flakyVtClient) Rollback() error {
+	c.rollbackCount++
+	return nil
+}
+func (c *flakyVtClient) Close() {}
+func (c *flakyVtClient) ExecuteFetch(query string, maxrows int, wantfields bool) (*proto.QueryResult, error) {
+	c.executeCount++
+	if c.attempt <= c.failUntilAttempt {
+		return nil, mysql.NewSqlError(1213, "Deadlock found when trying to get lock")
+	}
+	return &proto.QueryResult{RowsAffected: 1}, nil
+}
+
+// newBufferedDummyVtClient returns a DummyVtClient that writes to buf
+// instead of os.Stdout, so its output can be asserted on in tests.
+func newBufferedDummyVtClient(buf *bytes.Buffer) *DummyVtClient {
+	return &DummyVtClient{bufio.NewWriterSize(buf, 1024)}
+}
+
+func newTestBinlogPlayer(t *testing.T, dbClient VtClient, databaseRewrites, tableRewrites map[string]string) *BinlogPlayer {
+	return newTestBinlogPlayerWithTxnBatch(t, dbClient, databaseRewrites, tableRewrites, 1)
+}
+
+// newTestBinlogPlayerWithTxnBatch is like newTestBinlogPlayer but lets the
+// caller pick txnBatch, the number of committed transactions processBinlogEvent
+// buffers before calling flushTxnBatch.
+func newTestBinlogPlayerWithTxnBatch(t *testing.T, dbClient VtClient, databaseRewrites, tableRewrites map[string]string, txnBatch int) *BinlogPlayer {
+	startPosition := &binlogRecoveryState{
+		Addr:     "test-addr",
+		Position: cproto.ReplicationCoordinates{GroupId: "1"},
+	}
+	blp, err := NewBinlogPlayerWithHandlers(dbClient, key.KeyRange{}, 1, startPosition, nil, txnBatch, time.Minute, true, nil, databaseRewrites, tableRewrites, 0, nil)
+	if err != nil {
+		t.Fatalf("NewBinlogPlayerWithHandlers failed: %v", err)
+	}
+	return blp
+}
+
+func beginEvent() *cproto.BinlogResponse {
+	ev := &cproto.BinlogResponse{}
+	ev.Data.SqlType = cproto.BEGIN
+	return ev
+}
+
+func commitEvent() *cproto.BinlogResponse {
+	ev := &cproto.BinlogResponse{}
+	ev.Data.SqlType = cproto.COMMIT
+	return ev
+}
+
+func dmlEvent(sql string) *cproto.BinlogResponse {
+	ev := &cproto.BinlogResponse{}
+	ev.Data.SqlType = cproto.DML
+	ev.Data.Sql = []string{sql}
+	return ev
+}
+
+func dmlWithStreamComment(table string) string {
+	return "insert into `" + table + "` values (1) /* _stream " + table + " (id ) (1 ); */"
+}
+
+// TestTableRewriteAppliedBeforeExecute asserts that a DML naming a table
+// with a TableRewrites entry is executed against the rewritten name, not
+// the original one.
+func TestTableRewriteAppliedBeforeExecute(t *testing.T) {
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	blp := newTestBinlogPlayer(t, dbClient, nil, map[string]string{"source_table": "target_table"})
+
+	if err := blp.processBinlogEvent(beginEvent()); err != nil {
+		t.Fatalf("BEGIN: %v", err)
+	}
+	if err := blp.processBinlogEvent(dmlEvent(dmlWithStreamComment("source_table"))); err != nil {
+		t.Fatalf("DML: %v", err)
+	}
+	if err := blp.processBinlogEvent(commitEvent()); err != nil {
+		t.Fatalf("COMMIT: %v", err)
+	}
+	dbClient.stdout.Flush()
+
+	out := buf.String()
+	if strings.Contains(out, "`source_table`") {
+		t.Errorf("expected source_table to be rewritten away, got %q", out)
+	}
+	if !strings.Contains(out, "`target_table`") {
+		t.Errorf("expected target_table in executed sql, got %q", out)
+	}
+}
+
+// TestFlushTxnBatchRetriesOnDeadlock asserts that a retryable mysql error
+// (e.g. deadlock) during a flush is retried, via flushTxnBatchSerial's
+// isRetryableError-driven retry loop (the same policy runInNewTxn applies
+// for a real *DBClient), until it succeeds, instead of failing the batch
+// outright.
+func TestFlushTxnBatchRetriesOnDeadlock(t *testing.T) {
+	dbClient := &flakyVtClient{failUntilAttempt: 2}
+	blp := newTestBinlogPlayer(t, dbClient, nil, nil)
+	blp.SetTxnRetryPolicy(TxnRetryPolicy{MaxRetries: 5, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1})
+
+	if err := blp.processBinlogEvent(beginEvent()); err != nil {
+		t.Fatalf("BEGIN: %v", err)
+	}
+	if err := blp.processBinlogEvent(dmlEvent(dmlWithStreamComment("t1"))); err != nil {
+		t.Fatalf("DML: %v", err)
+	}
+	if err := blp.processBinlogEvent(commitEvent()); err != nil {
+		t.Fatalf("COMMIT: %v", err)
+	}
+
+	if dbClient.attempt != 3 {
+		t.Errorf("expected 3 attempts (2 failures + 1 success), got %v", dbClient.attempt)
+	}
+	if dbClient.commitCount != 1 {
+		t.Errorf("expected exactly 1 commit, got %v", dbClient.commitCount)
+	}
+	if dbClient.rollbackCount != 2 {
+		t.Errorf("expected 2 rollbacks (one per failed attempt), got %v", dbClient.rollbackCount)
+	}
+}
+
+// TestFlushTxnBatchParallelRetriesOnDeadlock asserts that a retryable error
+// from one worker during a parallel flush rolls back every worker's
+// bucket - even one that applied cleanly - and retries the whole batch,
+// instead of leaving a clean worker's bucket committed ahead of the
+// checkpoint, or aborting the player run on the first transient deadlock.
+func TestFlushTxnBatchParallelRetriesOnDeadlock(t *testing.T) {
+	workerClients := []*flakyVtClient{{failUntilAttempt: 1}, {}}
+	nextWorker := 0
+	dbClientFactory := func() (VtClient, error) {
+		c := workerClients[nextWorker]
+		nextWorker++
+		return c, nil
+	}
+
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	startPosition := &binlogRecoveryState{
+		Addr:     "test-addr",
+		Position: cproto.ReplicationCoordinates{GroupId: "1"},
+	}
+	blp, err := NewBinlogPlayerWithHandlers(dbClient, key.KeyRange{}, 1, startPosition, nil, 2, time.Minute, true, nil, nil, nil, 2, dbClientFactory)
+	if err != nil {
+		t.Fatalf("NewBinlogPlayerWithHandlers failed: %v", err)
+	}
+	blp.SetTxnRetryPolicy(TxnRetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1})
+
+	for _, table := range []string{"t1", "t2"} {
+		if err := blp.processBinlogEvent(beginEvent()); err != nil {
+			t.Fatalf("BEGIN %v: %v", table, err)
+		}
+		if err := blp.processBinlogEvent(dmlEvent(dmlWithStreamComment(table))); err != nil {
+			t.Fatalf("DML %v: %v", table, err)
+		}
+		if err := blp.processBinlogEvent(commitEvent()); err != nil {
+			t.Fatalf("COMMIT %v: %v", table, err)
+		}
+	}
+
+	for i, worker := range workerClients {
+		if worker.attempt != 2 {
+			t.Errorf("worker %v: expected 2 attempts (1 failure + 1 success), got %v", i, worker.attempt)
+		}
+		if worker.commitCount != 1 {
+			t.Errorf("worker %v: expected exactly 1 commit, got %v", i, worker.commitCount)
+		}
+		if worker.rollbackCount != 1 {
+			t.Errorf("worker %v: expected exactly 1 rollback (from the batch's one failed attempt), got %v", i, worker.rollbackCount)
+		}
+	}
+}
+
+// TestDatabaseRewriteAppliedToDdl asserts that handleDdl rewrites a quoted
+// database name with a DatabaseRewrites entry via rewriteDatabase.
+func TestDatabaseRewriteAppliedToDdl(t *testing.T) {
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	blp := newTestBinlogPlayer(t, dbClient, map[string]string{"source_db": "target_db"}, nil)
+
+	ddlEvent := &cproto.BinlogResponse{}
+	ddlEvent.Data.SqlType = cproto.DDL
+	ddlEvent.Data.Sql = []string{"create table `source_db`.`t1` (id int)"}
+
+	if err := blp.processBinlogEvent(ddlEvent); err != nil {
+		t.Fatalf("DDL: %v", err)
+	}
+	dbClient.stdout.Flush()
+
+	out := buf.String()
+	if strings.Contains(out, "`source_db`") {
+		t.Errorf("expected source_db to be rewritten away, got %q", out)
+	}
+	if !strings.Contains(out, "`target_db`") {
+		t.Errorf("expected target_db in executed ddl, got %q", out)
+	}
+}
+
+// TestIsCaughtUpUsesSourceEventTimestamp asserts that IsCaughtUp/
+// TimeSinceLastEvent reflect the lag between now and the last event's own
+// source timestamp, not how recently the player received bytes from the
+// socket - so a stale source event is correctly reported as not caught up
+// even though it was just received.
+func TestIsCaughtUpUsesSourceEventTimestamp(t *testing.T) {
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	blp := newTestBinlogPlayer(t, dbClient, nil, nil)
+
+	staleTimestamp := time.Now().Add(-1 * time.Hour).Unix()
+	begin, dml, commit := beginEvent(), dmlEvent(dmlWithStreamComment("t1")), commitEvent()
+	begin.Position.Timestamp = staleTimestamp
+	dml.Position.Timestamp = staleTimestamp
+	commit.Position.Timestamp = staleTimestamp
+
+	if err := blp.processBinlogEvent(begin); err != nil {
+		t.Fatalf("BEGIN: %v", err)
+	}
+	if err := blp.processBinlogEvent(dml); err != nil {
+		t.Fatalf("DML: %v", err)
+	}
+	if err := blp.processBinlogEvent(commit); err != nil {
+		t.Fatalf("COMMIT: %v", err)
+	}
+
+	if blp.IsCaughtUp(DefaultCaughtUpThreshold) {
+		t.Errorf("expected not caught up: last event is an hour stale by source timestamp")
+	}
+	if lag := blp.TimeSinceLastEvent(); lag < 55*time.Minute {
+		t.Errorf("expected lag near an hour, got %v", lag)
+	}
+
+	freshBegin, freshDml, freshCommit := beginEvent(), dmlEvent(dmlWithStreamComment("t1")), commitEvent()
+	freshTimestamp := time.Now().Unix()
+	freshBegin.Position.Timestamp = freshTimestamp
+	freshDml.Position.Timestamp = freshTimestamp
+	freshCommit.Position.Timestamp = freshTimestamp
+
+	if err := blp.processBinlogEvent(freshBegin); err != nil {
+		t.Fatalf("BEGIN: %v", err)
+	}
+	if err := blp.processBinlogEvent(freshDml); err != nil {
+		t.Fatalf("DML: %v", err)
+	}
+	if err := blp.processBinlogEvent(freshCommit); err != nil {
+		t.Fatalf("COMMIT: %v", err)
+	}
+
+	if !blp.IsCaughtUp(DefaultCaughtUpThreshold) {
+		t.Errorf("expected caught up after a recent event, lag %v", blp.TimeSinceLastEvent())
+	}
+}
+
+// TestEventHandlerInvocationOrder asserts that registered event handlers
+// fire once per event, in the same order the events were fed in.
+func TestEventHandlerInvocationOrder(t *testing.T) {
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	blp := newTestBinlogPlayer(t, dbClient, nil, nil)
+
+	var order []string
+	blp.RegisterEventHandler(cproto.BEGIN, func(blp *BinlogPlayer, event *cproto.BinlogResponse) error {
+		order = append(order, "BEGIN")
+		return nil
+	})
+	blp.RegisterEventHandler(cproto.DML, func(blp *BinlogPlayer, event *cproto.BinlogResponse) error {
+		order = append(order, "DML")
+		return nil
+	})
+	blp.RegisterEventHandler(cproto.COMMIT, func(blp *BinlogPlayer, event *cproto.BinlogResponse) error {
+		order = append(order, "COMMIT")
+		return nil
+	})
+
+	if err := blp.processBinlogEvent(beginEvent()); err != nil {
+		t.Fatalf("BEGIN: %v", err)
+	}
+	if err := blp.processBinlogEvent(dmlEvent(dmlWithStreamComment("t1"))); err != nil {
+		t.Fatalf("DML: %v", err)
+	}
+	if err := blp.processBinlogEvent(commitEvent()); err != nil {
+		t.Fatalf("COMMIT: %v", err)
+	}
+
+	want := []string{"BEGIN", "DML", "COMMIT"}
+	if len(order) != len(want) {
+		t.Fatalf("handler invocation order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("handler invocation order = %v, want %v", order, want)
+		}
+	}
+}
+
+// recordingSelector is a SourceSelector test double that records every
+// Next call it receives and then interrupts the player, so a test can
+// drive ApplyBinlogEvents through exactly one failover decision.
+type recordingSelector struct {
+	calls       []selectorCall
+	interrupted chan struct{}
+}
+
+type selectorCall struct {
+	current string
+	err     error
+}
+
+func (s *recordingSelector) Next(current string, lastErr error) (string, time.Duration) {
+	s.calls = append(s.calls, selectorCall{current, lastErr})
+	close(s.interrupted)
+	return current, 0
+}
+
+// TestApplyBinlogEventsFailsOverOnDialError asserts that a dial failure
+// against recoveryState.Addr is routed through SourceSelector.Next, the
+// same as any other error from applyBinlogEventsOnce - it isn't swallowed
+// or retried against the same address forever.
+func TestApplyBinlogEventsFailsOverOnDialError(t *testing.T) {
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	blp := newTestBinlogPlayer(t, dbClient, nil, nil)
+
+	interrupted := make(chan struct{})
+	selector := &recordingSelector{interrupted: interrupted}
+	blp.SourceSelector = selector
+
+	if err := blp.ApplyBinlogEvents(interrupted); err != nil {
+		t.Fatalf("ApplyBinlogEvents: %v", err)
+	}
+
+	if len(selector.calls) != 1 {
+		t.Fatalf("expected exactly 1 failover decision, got %v", len(selector.calls))
+	}
+	if selector.calls[0].current != blp.recoveryState.Addr {
+		t.Errorf("expected failover from %q, got %q", blp.recoveryState.Addr, selector.calls[0].current)
+	}
+	if selector.calls[0].err == nil {
+		t.Errorf("expected the dial error to be passed to SourceSelector.Next, got nil")
+	}
+}
+
+// TestConsumeBinlogStreamDoesNotFailOverOnEOF asserts that a response
+// carrying a normal end-of-stream EOF marker returns nil from
+// consumeBinlogStream even though processBinlogEvent fails on it (there's
+// nothing left to apply), so applyBinlogEventsOnce won't treat reaching
+// the end of the stream as a failure worth failing over for.
+func TestConsumeBinlogStreamDoesNotFailOverOnEOF(t *testing.T) {
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	blp := newTestBinlogPlayer(t, dbClient, nil, nil)
+
+	responseChan := make(chan *cproto.BinlogResponse, 1)
+	eofEvent := &cproto.BinlogResponse{Error: "EOF"}
+	responseChan <- eofEvent
+	close(responseChan)
+
+	if err := blp.consumeBinlogStream(responseChan, make(chan struct{})); err != nil {
+		t.Errorf("expected no error on a normal EOF end of stream, got %v", err)
+	}
+}
+
+// TestConsumeBinlogStreamFailsOverOnNonEOFError asserts that a genuine,
+// non-EOF processing failure is still surfaced as an error, so
+// applyBinlogEventsOnce keeps failing over on real errors.
+func TestConsumeBinlogStreamFailsOverOnNonEOFError(t *testing.T) {
+	var buf bytes.Buffer
+	dbClient := newBufferedDummyVtClient(&buf)
+	blp := newTestBinlogPlayer(t, dbClient, nil, nil)
+
+	responseChan := make(chan *cproto.BinlogResponse, 1)
+	responseChan <- commitEvent()
+	close(responseChan)
+
+	if err := blp.consumeBinlogStream(responseChan, make(chan struct{})); err == nil {
+		t.Errorf("expected an error for a COMMIT with no open transaction, got nil")
+	}
+}